@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"github.com/libgit2/git2go"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"time"
+)
+
+// authorMapPath is the path to an optional YAML file mapping SQL Server logins to git authors, set
+// via the -author-map flag.
+var authorMapPath string
+
+// loadedAuthors is the authorMap parsed from authorMapPath once flags have been parsed. It is nil
+// (and every commit falls back to conf.gitName/gitEmail) when -author-map was not set.
+var loadedAuthors authorMap
+
+func init() {
+	flag.StringVar(&authorMapPath, "author-map", "", "path to a YAML file mapping SQL Server logins to git authors, e.g. 'DOMAIN\\jbloggs: {name: Jane Bloggs, email: jane@example.com}'")
+}
+
+// gitAuthor is a single entry in an author map file.
+type gitAuthor struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+// authorMap maps a SQL Server login to the git author that should be credited for changes made by
+// that login.
+type authorMap map[string]gitAuthor
+
+// loadAuthorMap loads an author map from path. An empty path is not an error; it just means no
+// logins will be mapped, and the default conf.gitName/gitEmail signature is used for every commit.
+func loadAuthorMap(path string) (authorMap, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := authorMap{}
+	if err = yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// signatureFor returns the git.Signature to credit for a change made by login, falling back to the
+// given default signature when login is unknown or unmapped.
+func (m authorMap) signatureFor(login string, fallback *git.Signature) *git.Signature {
+	author, ok := m[login]
+	if !ok {
+		return fallback
+	}
+
+	return &git.Signature{Name: author.Name, Email: author.Email, When: time.Now()}
+}
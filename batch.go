@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sync"
+)
+
+// batchConfig describes a whole estate of databases (and optionally servers) to mirror in a single
+// run, as loaded from the file passed with -c.
+type batchConfig struct {
+	// OutDir is the root directory that per-target subdirectories live under. Falls back to the
+	// -o flag's value when empty.
+	OutDir string `yaml:"outDir"`
+	// Concurrency is the number of targets to process at once. 0 or 1 means sequential.
+	Concurrency int `yaml:"concurrency"`
+	// GitName and GitEmail are the default commit author, overridable per-target.
+	GitName  string `yaml:"gitName"`
+	GitEmail string `yaml:"gitEmail"`
+	// Branch is the default git branch commits are made on, overridable per-target. Falls back to
+	// the -branch flag's value when empty.
+	Branch string `yaml:"branch"`
+
+	Targets []batchTarget `yaml:"targets"`
+}
+
+// batchTarget describes a single database within a batchConfig.
+type batchTarget struct {
+	Host     string `yaml:"host"`
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+
+	// SubDir, if set, is used instead of Database as the name of the subdirectory for this target,
+	// allowing multiple targets to share a database name.
+	SubDir string `yaml:"subDir"`
+	// Branch overrides the batchConfig-wide Branch (or the -branch flag) when committing this target.
+	Branch string `yaml:"branch"`
+
+	// GitName and GitEmail override the batchConfig-wide commit author for this target.
+	GitName  string `yaml:"gitName"`
+	GitEmail string `yaml:"gitEmail"`
+}
+
+// loadBatchConfig reads and parses the batch config file at path. Both YAML and JSON are accepted,
+// since JSON is a subset of YAML.
+func loadBatchConfig(path string) (*batchConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &batchConfig{}
+	if err = yaml.Unmarshal(data, batch); err != nil {
+		return nil, err
+	}
+
+	if len(batch.Targets) == 0 {
+		return nil, fmt.Errorf("%v: no targets defined", path)
+	}
+
+	return batch, nil
+}
+
+// toConfig builds the per-database config used by processDatabase for this target, inheriting
+// defaults from the enclosing batchConfig where the target doesn't override them.
+func (t *batchTarget) toConfig(batch *batchConfig) config {
+	subDir := t.SubDir
+	if len(subDir) == 0 {
+		subDir = t.Database
+	}
+
+	branch := t.Branch
+	if len(branch) == 0 {
+		branch = batch.Branch
+	}
+	if len(branch) == 0 {
+		branch = conf.branch
+	}
+
+	gitName := t.GitName
+	if len(gitName) == 0 {
+		gitName = batch.GitName
+	}
+
+	gitEmail := t.GitEmail
+	if len(gitEmail) == 0 {
+		gitEmail = batch.GitEmail
+	}
+
+	outDir := batch.OutDir
+	if len(outDir) == 0 {
+		outDir = conf.outDir
+	}
+
+	return config{
+		db: dbConfig{
+			host:     t.Host,
+			database: t.Database,
+			user:     t.User,
+			password: t.Password,
+		},
+		outDir:     outDir,
+		outSubDir:  subDir,
+		branch:     branch,
+		gitName:    gitName,
+		gitEmail:   gitEmail,
+		push:       conf.push,
+		commitMode: conf.commitMode,
+	}
+}
+
+// runBatch processes every target in the batch config, sequentially or in bounded parallel
+// depending on batch.Concurrency, and reports a combined error if any target failed.
+func runBatch(batch *batchConfig) error {
+	concurrency := batch.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	errs := make([]error, len(batch.Targets))
+
+	var wg sync.WaitGroup
+	for i, target := range batch.Targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, target batchTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			targetConf := target.toConfig(batch)
+			if err := targetConf.isValid(); err != nil {
+				errs[i] = fmt.Errorf("%v: %v", target.Database, err)
+				return
+			}
+
+			log.Info().Str("database", target.Database).Str("host", target.Host).Msg("processing database")
+			if err := processDatabase(targetConf); err != nil {
+				errs[i] = fmt.Errorf("%v: %v", target.Database, err)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	errCount := 0
+	for _, err := range errs {
+		if err != nil {
+			log.Error().Err(err).Msg("target failed")
+			errCount++
+		}
+	}
+
+	if errCount > 0 {
+		return fmt.Errorf("%v of %v targets failed", errCount, len(batch.Targets))
+	}
+
+	return nil
+}
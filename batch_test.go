@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestBatchTargetToConfig(t *testing.T) {
+	origConf := conf
+	defer func() { conf = origConf }()
+	conf = config{outDir: "/default", branch: "master"}
+
+	batch := &batchConfig{
+		OutDir:   "/batch-root",
+		GitName:  "batch-bot",
+		GitEmail: "batch@example.com",
+		Branch:   "batch-default",
+	}
+
+	cases := []struct {
+		name       string
+		target     batchTarget
+		wantDB     string
+		wantSubDir string
+		wantBranch string
+	}{
+		{
+			name:       "defaults from batch",
+			target:     batchTarget{Database: "mydb"},
+			wantDB:     "mydb",
+			wantSubDir: "mydb",
+			wantBranch: "batch-default",
+		},
+		{
+			name:       "subDir and branch overrides, database unchanged",
+			target:     batchTarget{Database: "mydb", SubDir: "mydb-2", Branch: "feature"},
+			wantDB:     "mydb",
+			wantSubDir: "mydb-2",
+			wantBranch: "feature",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.target.toConfig(batch)
+
+			if got.db.database != c.wantDB {
+				t.Errorf("db.database = %q, want %q", got.db.database, c.wantDB)
+			}
+			if got.subDir() != c.wantSubDir {
+				t.Errorf("subDir() = %q, want %q", got.subDir(), c.wantSubDir)
+			}
+			if got.branch != c.wantBranch {
+				t.Errorf("branch = %q, want %q", got.branch, c.wantBranch)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// removeStaleObjects deletes mirrored files for objects that are no longer present in objects, so
+// the mirror doesn't accumulate .sql files for procedures/views/etc. that have since been dropped
+// from the database. Only the given types are swept, so excluded object types are left untouched.
+func removeStaleObjects(storage Storage, subDir string, types []objectType, objects []dbObject) error {
+	current := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		current[filepath.Join(subDir, obj.relPath())] = true
+	}
+
+	for _, t := range types {
+		existing, err := storage.List(filepath.Join(subDir, string(t)))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range existing {
+			if current[path] {
+				continue
+			}
+
+			log.Info().Str("path", path).Msg("removing stale object")
+			if err = storage.Delete(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/libgit2/git2go"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// getRepo either gets the existing repo from the given path or initialise a new one.
+func getRepo(repoPath string) (repo *git.Repository, err error) {
+	// Return the repo if we have one already
+	repo, err = git.OpenRepository(repoPath)
+	// Or init a new one
+	if err != nil {
+		repo, err = git.InitRepository(repoPath, false)
+	}
+
+	return repo, err
+}
+
+// getHeadCommit gets the head commit from branch for the given repo, or nil if nothing has been
+// committed to branch yet (as in an empty repo)
+func getHeadCommit(repo *git.Repository, branch string) (commit *git.Commit, err error) {
+	head, err := repo.LookupBranch(branch, git.BranchLocal)
+	if err != nil && git.IsErrorCode(err, git.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commit, err = repo.LookupCommit(head.Target())
+
+	return commit, err
+}
+
+// objectMetaByPath fetches objectMeta for each of objects, keyed by the relative path it was
+// written to, so commit message building can look metadata up by the paths the diff reports.
+// Objects whose metadata can't be read (e.g. since deleted) are silently omitted; a commit message
+// missing provenance for one object is preferable to failing the whole commit over it.
+func objectMetaByPath(db *sqlx.DB, subDir string, objects []dbObject) map[string]objectMeta {
+	metas := make(map[string]objectMeta, len(objects))
+
+	for _, obj := range objects {
+		meta, err := getObjectMetadata(db, obj)
+		if err != nil {
+			continue
+		}
+
+		metas[filepath.Join(subDir, obj.relPath())] = meta
+	}
+
+	return metas
+}
+
+// commitChanges records the changes found in the given config's outDir as one or more commits,
+// depending on conf.commitMode.
+func commitChanges(repo *git.Repository, conf config, db *sqlx.DB, objects []dbObject) (err error) {
+	idx, err := repo.Index()
+	if err != nil {
+		return err
+	}
+
+	pathspec := []string{filepath.Join(conf.subDir(), "*")}
+
+	if err = idx.AddAll(pathspec, git.IndexAddDefault, nil); err != nil {
+		return err
+	}
+
+	if err = idx.UpdateAll(pathspec, nil); err != nil {
+		return err
+	}
+
+	changes, err := diffIndexAgainstHead(repo, idx, conf.branch)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Info().Msg("no changes to commit")
+		return nil
+	}
+
+	if err = idx.Write(); err != nil {
+		return err
+	}
+
+	metas := objectMetaByPath(db, conf.subDir(), objects)
+
+	if conf.commitMode == "per-object" {
+		return commitPerObject(repo, conf, changes, metas)
+	}
+
+	return commitBatch(repo, conf, idx, changes, metas)
+}
+
+// commitBatch records every change found in the index as a single commit.
+func commitBatch(repo *git.Repository, conf config, idx *git.Index, changes []objectChange, metas map[string]objectMeta) error {
+	treeId, err := idx.WriteTree()
+	if err != nil {
+		return err
+	}
+
+	tree, err := repo.LookupTree(treeId)
+	if err != nil {
+		return err
+	}
+
+	headCommit, err := getHeadCommit(repo, conf.branch)
+	if err != nil {
+		return err
+	}
+
+	signature := &git.Signature{Name: conf.gitName, Email: conf.gitEmail, When: time.Now()}
+	message := buildCommitMessage(conf.db.database, changes, metas)
+
+	log.Info().Int("files", len(changes)).Msg("committing updates")
+	appMetrics.setLastCommitSize(uint64(len(changes)))
+
+	ref := fmt.Sprintf("refs/heads/%v", conf.branch)
+	if headCommit != nil {
+		_, err = repo.CreateCommit(ref, signature, signature, message, tree, headCommit)
+	} else {
+		_, err = repo.CreateCommit(ref, signature, signature, message, tree)
+	}
+
+	if err != nil {
+		appMetrics.addError("commit")
+	}
+
+	return err
+}
+
+// commitPerObject records one commit per changed path, each containing only that one path's change,
+// attributed (via loadedAuthors) to the login that last altered the corresponding database object -
+// so `git blame` reflects who actually made the change in the database rather than whoever ran
+// spkeeper. It builds each commit's tree from the previous commit's tree plus a single path added or
+// removed, rather than from the (fully-staged) working index, so unrelated pending changes don't leak
+// into every commit.
+func commitPerObject(repo *git.Repository, conf config, changes []objectChange, metas map[string]objectMeta) error {
+	headCommit, err := getHeadCommit(repo, conf.branch)
+	if err != nil {
+		return err
+	}
+
+	ref := fmt.Sprintf("refs/heads/%v", conf.branch)
+
+	var baseTree *git.Tree
+	if headCommit != nil {
+		if baseTree, err = headCommit.Tree(); err != nil {
+			return err
+		}
+	} else if baseTree, err = emptyTree(repo); err != nil {
+		return err
+	}
+
+	idx, err := repo.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if err = idx.ReadTree(baseTree); err != nil {
+			return err
+		}
+
+		if change.Status == changeDeleted {
+			if err = idx.RemoveByPath(change.Path); err != nil {
+				return err
+			}
+		} else if err = idx.AddByPath(change.Path); err != nil {
+			return err
+		}
+
+		treeId, err := idx.WriteTree()
+		if err != nil {
+			return err
+		}
+
+		tree, err := repo.LookupTree(treeId)
+		if err != nil {
+			return err
+		}
+
+		fallback := &git.Signature{Name: conf.gitName, Email: conf.gitEmail, When: time.Now()}
+		meta := metas[change.Path]
+		signature := loadedAuthors.signatureFor(meta.Login, fallback)
+
+		message := buildObjectCommitMessage(change, meta)
+
+		log.Info().Str("path", change.Path).Str("status", string(change.Status)).Msg("committing object")
+
+		var commitId *git.Oid
+		if headCommit != nil {
+			commitId, err = repo.CreateCommit(ref, signature, signature, message, tree, headCommit)
+		} else {
+			commitId, err = repo.CreateCommit(ref, signature, signature, message, tree)
+		}
+		if err != nil {
+			appMetrics.addError("commit")
+			return err
+		}
+
+		appMetrics.setLastCommitSize(1)
+
+		if headCommit, err = repo.LookupCommit(commitId); err != nil {
+			return err
+		}
+		baseTree = tree
+	}
+
+	// Leave the on-disk index reflecting the final state we just committed.
+	if err = idx.ReadTree(baseTree); err != nil {
+		return err
+	}
+	return idx.Write()
+}
+
+// emptyTree returns the tree with no entries, used as the base for the very first commit into an
+// empty repo.
+func emptyTree(repo *git.Repository) (*git.Tree, error) {
+	builder, err := repo.TreeBuilder()
+	if err != nil {
+		return nil, err
+	}
+
+	oid, err := builder.Write()
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.LookupTree(oid)
+}
+
+// buildCommitMessage builds a structured commit message for a batch commit, grouping changed files
+// into Added/Modified/Deleted sections and annotating each with its database-side provenance where
+// available.
+func buildCommitMessage(database string, changes []objectChange, metas map[string]objectMeta) string {
+	var added, modified, deleted []string
+
+	for _, change := range changes {
+		line := change.Path
+		if meta, ok := metas[change.Path]; ok {
+			line = fmt.Sprintf("%v (modified %v", line, meta.ModifyDate.Format("2006-01-02 15:04:05"))
+			if len(meta.Login) > 0 {
+				line += fmt.Sprintf(" by %v", meta.Login)
+			}
+			line += ")"
+		}
+
+		switch change.Status {
+		case changeAdded:
+			added = append(added, line)
+		case changeDeleted:
+			deleted = append(deleted, line)
+		default:
+			modified = append(modified, line)
+		}
+	}
+
+	var sections []string
+	sections = append(sections, fmt.Sprintf("Update with objects from database '%v'", database))
+
+	if len(added) > 0 {
+		sections = append(sections, "Added:\n"+strings.Join(added, "\n"))
+	}
+	if len(modified) > 0 {
+		sections = append(sections, "Modified:\n"+strings.Join(modified, "\n"))
+	}
+	if len(deleted) > 0 {
+		sections = append(sections, "Deleted:\n"+strings.Join(deleted, "\n"))
+	}
+
+	return strings.Join(sections, "\n\n")
+}
+
+// buildObjectCommitMessage builds the commit message for a single object in per-object commit mode.
+func buildObjectCommitMessage(change objectChange, meta objectMeta) string {
+	message := fmt.Sprintf("%v: %v", change.Status, change.Path)
+
+	if len(meta.Login) > 0 {
+		message += fmt.Sprintf("\n\nLast modified %v by %v", meta.ModifyDate.Format("2006-01-02 15:04:05"), meta.Login)
+	} else if !meta.ModifyDate.IsZero() {
+		message += fmt.Sprintf("\n\nLast modified %v", meta.ModifyDate.Format("2006-01-02 15:04:05"))
+	}
+
+	return message
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/libgit2/git2go"
+)
+
+// changeStatus classifies how a path differs between HEAD and the index.
+type changeStatus string
+
+const (
+	changeAdded    changeStatus = "Added"
+	changeModified changeStatus = "Modified"
+	changeDeleted  changeStatus = "Deleted"
+)
+
+// objectChange is a single file's status in a diff between HEAD and the index.
+type objectChange struct {
+	Path   string
+	Status changeStatus
+}
+
+// diffIndexAgainstHead diffs the given index against repo's current HEAD tree (an empty tree for a
+// brand new repo) and returns each changed path tagged with how it changed. This is used
+// instead of AddAll's own changed-file callback so renames/deletes are reported accurately.
+func diffIndexAgainstHead(repo *git.Repository, idx *git.Index, branch string) (changes []objectChange, err error) {
+	var headTree *git.Tree
+
+	headCommit, err := getHeadCommit(repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	if headCommit != nil {
+		headTree, err = headCommit.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diff, err := repo.DiffTreeToIndex(headTree, idx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+
+	numDeltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < numDeltas; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, classifyDelta(delta.Status, delta.OldFile.Path, delta.NewFile.Path))
+	}
+
+	return changes, nil
+}
+
+// classifyDelta maps a single diff delta to the objectChange it represents. Pulled out of
+// diffIndexAgainstHead so the Added/Modified/Deleted classification can be unit tested without a
+// real repository.
+func classifyDelta(status git.Delta, oldPath, newPath string) objectChange {
+	switch status {
+	case git.DeltaAdded:
+		return objectChange{Path: newPath, Status: changeAdded}
+	case git.DeltaDeleted:
+		// The new side of a deletion delta is empty; the path lives on the old side.
+		return objectChange{Path: oldPath, Status: changeDeleted}
+	default:
+		return objectChange{Path: newPath, Status: changeModified}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libgit2/git2go"
+)
+
+func TestClassifyDelta(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   git.Delta
+		oldPath  string
+		newPath  string
+		expected objectChange
+	}{
+		{"added", git.DeltaAdded, "", "procedures/dbo.new_proc.sql", objectChange{Path: "procedures/dbo.new_proc.sql", Status: changeAdded}},
+		{"deleted", git.DeltaDeleted, "procedures/dbo.old_proc.sql", "", objectChange{Path: "procedures/dbo.old_proc.sql", Status: changeDeleted}},
+		{"modified", git.DeltaModified, "views/dbo.v.sql", "views/dbo.v.sql", objectChange{Path: "views/dbo.v.sql", Status: changeModified}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := classifyDelta(c.status, c.oldPath, c.newPath)
+			if got != c.expected {
+				t.Errorf("classifyDelta(%v, %q, %q) = %+v, want %+v", c.status, c.oldPath, c.newPath, got, c.expected)
+			}
+		})
+	}
+}
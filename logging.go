@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"github.com/rs/zerolog"
+	"os"
+	"strings"
+)
+
+// log is the global structured logger, configured by initLogging once flags have been parsed.
+var log zerolog.Logger
+
+var logLevel string
+var logFormat string
+
+func init() {
+	flag.StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn, error")
+	flag.StringVar(&logFormat, "log-format", "text", "log output format: text or json")
+}
+
+// initLogging configures the global logger from the -log-level/-log-format flags. It must be called
+// after flag.Parse.
+func initLogging() {
+	level, err := zerolog.ParseLevel(logLevel)
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(level)
+
+	out := os.Stderr
+	if strings.ToLower(logFormat) == "json" {
+		log = zerolog.New(out).With().Timestamp().Logger()
+	} else {
+		log = zerolog.New(zerolog.ConsoleWriter{Out: out, NoColor: true}).With().Timestamp().Logger()
+	}
+}
@@ -1,30 +1,49 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/jmoiron/sqlx"
-	"github.com/libgit2/git2go"
 	_ "github.com/minus5/gofreetds"
-	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync/atomic"
-	"time"
 )
 
+// batchFilePath holds the path to an optional multi-database config file, set via the -c flag.
+var batchFilePath string
+
 // config holds database, output location & git config
 type config struct {
 	db dbConfig
-	// Full path to the directory where SPs should be saved
+	// Full path to the directory (or storage URL) where objects should be saved
 	outDir string
+	// outSubDir, if set, is used instead of db.database as the name of the subdirectory objects are
+	// written under, letting multiple targets share a database name (see batchTarget.SubDir).
+	outSubDir string
+	// branch is the git branch commits are made (and pushed from) on. Defaults to "master".
+	branch string
 	// User name to use when committing
 	gitName string
 	// Email address to use when committing
 	gitEmail string
+	// push holds the optional remote git hosting config used to publish the mirror after committing.
+	push pushConfig
+	// commitMode is "batch" (one commit for the whole run) or "per-object" (one commit per changed
+	// object, attributed to whichever login last altered it).
+	commitMode string
+}
+
+// subDir returns the directory objects are written under, relative to outDir: outSubDir when set,
+// falling back to the database name otherwise. db.database itself is reserved for the connection
+// string, so that a target can mirror under a different directory than the database it connects to.
+func (c *config) subDir() string {
+	if len(c.outSubDir) > 0 {
+		return c.outSubDir
+	}
+	return c.db.database
 }
 
 // isValid checks if the config is valid.
@@ -33,8 +52,15 @@ func (c *config) isValid() error {
 		return errors.New("Missing output directory")
 	}
 
-	if _, err := os.Stat(c.outDir); os.IsNotExist(err) {
-		return errors.New("Output directory does not exist")
+	storage, err := newStorage(c.outDir)
+	if err != nil {
+		return err
+	}
+
+	if storage.IsFilesystem() {
+		if _, err := os.Stat(c.outDir); os.IsNotExist(err) {
+			return errors.New("Output directory does not exist")
+		}
 	}
 
 	if len(c.gitName) == 0 {
@@ -82,9 +108,12 @@ func init() {
 	flag.StringVar(&conf.db.database, "d", "", "database name")
 	flag.StringVar(&conf.db.user, "u", "sa", "database username")
 	flag.StringVar(&conf.db.password, "p", "", "database password")
-	flag.StringVar(&conf.outDir, "o", "", "output directory")
+	flag.StringVar(&conf.outDir, "o", "", "output directory, or a s3://bucket/prefix or gs://bucket/prefix URL to write to instead")
 	flag.StringVar(&conf.gitName, "n", "spkeeper", "git commit name")
 	flag.StringVar(&conf.gitEmail, "e", "spkeeper@example.com", "git commit email")
+	flag.StringVar(&conf.branch, "branch", "master", "git branch to commit (and push) to")
+	flag.StringVar(&batchFilePath, "c", "", "path to a YAML/JSON batch config listing multiple databases/servers")
+	flag.StringVar(&conf.commitMode, "commit-mode", "batch", "\"batch\" for one commit per run, or \"per-object\" for one commit per changed object")
 }
 
 // checkFatal will exit with an error status when given a non-nil error
@@ -95,95 +124,54 @@ func checkFatal(err error) {
 	}
 }
 
-// getProcedureNames gets all stored procedure names from the given database
-func getProcedureNames(db *sqlx.DB) (names []string, err error) {
-	err = db.Select(&names, "SELECT ROUTINE_NAME FROM INFORMATION_SCHEMA.ROUTINES WHERE ROUTINE_TYPE='PROCEDURE'")
-
-	return names, err
-}
-
-// saveProcedure saves the stored procedure with the given name to disk.
-// The SP body will be written to a file named after the SP in outDir.
-func saveProcedure(db *sqlx.DB, name string, outDir string) (err error) {
-	outFileName := filepath.Join(conf.outDir, conf.db.database, fmt.Sprintf("%v.sql", name))
-	f, err := os.Create(outFileName)
-	if err != nil {
+// saveObject saves the definition of the given database object to the given Storage, under
+// subDir, laid out as <subDir>/<object type>/<schema>.<name>.sql.
+func saveObject(db *sqlx.DB, obj dbObject, subDir string, storage Storage) (err error) {
+	var buf bytes.Buffer
+	if err = writeObjectBody(db, obj, &buf); err != nil {
 		return err
 	}
-	defer f.Close()
-
-	fmt.Printf("Writing %v to: %v\n", name, outFileName)
-
-	w := bufio.NewWriter(f)
-	defer w.Flush()
 
-	return writeProcedureBody(db, name, w)
-}
+	relPath := filepath.Join(subDir, obj.relPath())
+	log.Debug().Str("object", obj.qualifiedName()).Str("path", relPath).Msg("writing object")
 
-// writeProcedureBody reads the body of the SP with the given name from the database and writes it
-// to the given Writer.
-func writeProcedureBody(db *sqlx.DB, name string, w io.Writer) (err error) {
-	rows, err := db.Query("EXEC sp_helptext ?", name)
-	if err != nil {
+	if err = storage.Write(relPath, buf.Bytes()); err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var text string
-		err = rows.Scan(&text)
-		if err != nil {
-			return errors.New(fmt.Sprintf("%v: %v", name, err.Error()))
-		}
+	appMetrics.addObjectsFetched(1)
+	appMetrics.addBytesWritten(uint64(buf.Len()))
 
-		_, err = w.Write([]byte(text))
-		if err != nil {
-			return errors.New(fmt.Sprintf("%v: %v", name, err.Error()))
-		}
-	}
 	return nil
 }
 
-// spSaver receives a stored procedure name on the names chan, saves the body of the named procedure
-// to disk and returns any error on the results chan.
-func spSaver(db *sqlx.DB, outDir string, names <-chan string, results chan<- error) {
-	for n := range names {
-		results <- saveProcedure(db, n, outDir)
+// objSaver receives a dbObject on the objects chan, saves its definition to the given Storage and
+// returns any error on the results chan.
+func objSaver(db *sqlx.DB, subDir string, storage Storage, objects <-chan dbObject, results chan<- error) {
+	for obj := range objects {
+		results <- saveObject(db, obj, subDir, storage)
 	}
 }
 
-// saveAllProcedures saves all of the stored procedures with the names given to a subdirectory of
-// the outDir specified in the passed config. This sub directory will be named after the database
-// they are being read from.
-// workerCount goroutines will be used for fetching procure bodies from the database.
-func saveAllProcedures(names []string, workerCount int, db *sqlx.DB, conf config) (err error) {
-	// Ensure the output subdirectory exists
-	// Make the db subdirectory
-	outDir := filepath.Join(conf.outDir, conf.db.database)
-	err = os.MkdirAll(outDir, 0700)
-	if err != nil {
-		return err
-	}
-
-	saveNames := make(chan string, 100)
+// saveAllObjects saves the definitions of all the given database objects to the given Storage, under
+// conf's output subdirectory.
+// workerCount goroutines will be used for fetching object definitions from the database.
+func saveAllObjects(objects []dbObject, workerCount int, db *sqlx.DB, conf config, storage Storage) (err error) {
+	saveObjects := make(chan dbObject, 100)
 	results := make(chan error, 100)
 	done := make(chan bool)
 	var errCount uint64 = 0
 
-	fmt.Println("Saving", len(names), "stored procedures")
+	log.Info().Int("count", len(objects)).Msg("saving database objects")
 
-	// Print any errors that occur when saving
+	// Log any errors that occur when saving
 	go func() {
-		for i := 0; i < len(names); i++ {
+		for i := 0; i < len(objects); i++ {
 			err = <-results
 			if err != nil {
-				// Report but don't fail on an SP's body being unreadable
-				if strings.Contains(err.Error(), "sql: expected 2 destination arguments in Scan, not 1") {
-					fmt.Fprintf(os.Stderr, "Error reading SQL for %v\n", err.Error())
-				} else {
-					fmt.Fprintln(os.Stderr, err.Error())
-					atomic.AddUint64(&errCount, 1)
-				}
+				log.Error().Err(err).Msg("error saving object")
+				appMetrics.addError("save")
+				atomic.AddUint64(&errCount, 1)
 			}
 		}
 
@@ -192,145 +180,114 @@ func saveAllProcedures(names []string, workerCount int, db *sqlx.DB, conf config
 
 	// Make our workers that will do the saving
 	for w := 0; w <= workerCount; w++ {
-		go spSaver(db, outDir, saveNames, results)
+		go objSaver(db, conf.subDir(), storage, saveObjects, results)
 	}
 
-	// Send all our SP names to our workers
-	for _, name := range names {
-		saveNames <- name
+	// Send all our objects to our workers
+	for _, obj := range objects {
+		saveObjects <- obj
 	}
-	close(saveNames)
+	close(saveObjects)
 
 	<-done
 
 	finalErrCount := atomic.LoadUint64(&errCount)
 	if finalErrCount > 0 {
-		return errors.New(fmt.Sprintf("%v errors occured while saving stored procedures", finalErrCount))
+		return errors.New(fmt.Sprintf("%v errors occured while saving database objects", finalErrCount))
 	}
 
 	return nil
 }
 
-// getRepo either gets the existing repo from the given path or initialise a new one.
-func getRepo(repoPath string) (repo *git.Repository, err error) {
-	// Return the repo if we have one already
-	repo, err = git.OpenRepository(repoPath)
-	// Or init a new one
-	if err != nil {
-		repo, err = git.InitRepository(repoPath, false)
-	}
-
-	return repo, err
-}
-
-// commitChanges creates a new commit containing all changes found in the given config's outDir.
-func commitChanges(repo *git.Repository, conf config) (err error) {
-	// Add all SP files to the index
-	idx, err := repo.Index()
+// processDatabase connects to the database described by conf, mirrors the objects selected by
+// --include/--exclude to conf.outDir and records the result via finishRun. This is the single-database pipeline
+// used both for the plain CLI flag case and for each entry of a batch config.
+func processDatabase(conf config) (err error) {
+	db, err := sqlx.Connect("mssql", conf.db.connectionString())
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	changedFiles := make([]string, 0, 0)
-
-	idx.AddAll([]string{filepath.Join(conf.db.database, "*")}, git.IndexAddDefault, func(path, spec string) int {
-		changedFiles = append(changedFiles, path)
-		return 0
-	})
+	storage, err := newStorage(conf.outDir)
 	if err != nil {
 		return err
 	}
 
-	// If nothing has changed in the index, we can finish here
-	if len(changedFiles) == 0 {
-		fmt.Println("No changes to commit")
-		return nil
-	}
-
-	treeId, err := idx.WriteTree()
+	types, err := objectFilter.objectTypes()
 	if err != nil {
 		return err
 	}
 
-	err = idx.Write()
+	// Get all of the object names from the database
+	objects, err := getObjects(db, types)
 	if err != nil {
 		return err
 	}
 
-	// Get stuff we need to create a commit
-	tree, err := repo.LookupTree(treeId)
-	if err != nil {
+	if err = saveAllObjects(objects, 5, db, conf, storage); err != nil {
 		return err
 	}
 
-	headCommit, err := getHeadCommit(repo)
-	if err != nil {
+	if err = removeStaleObjects(storage, conf.subDir(), types, objects); err != nil {
 		return err
 	}
 
-	signature := &git.Signature{
-		Name:  conf.gitName,
-		Email: conf.gitEmail,
-		When:  time.Now(),
-	}
-
-	message := buildCommitMessage(conf.db.database, changedFiles)
-
-	if headCommit != nil {
-		fmt.Printf("Committing updates to %v files\n", len(changedFiles))
-		_, err = repo.CreateCommit("refs/heads/master", signature, signature, message, tree, headCommit)
-	} else {
-		fmt.Printf("Creating initial commit containing %v files\n", len(changedFiles))
-		_, err = repo.CreateCommit("refs/heads/master", signature, signature, message, tree)
-	}
-
-	return err
+	return finishRun(conf, storage, db, objects)
 }
 
-// getHeadCommit gets the head commit from master for the given repo, or nil if the repo is empty
-func getHeadCommit(repo *git.Repository) (commit *git.Commit, err error) {
-	// Check if this is a new repo
-	_, err = repo.Head()
-	if err != nil && git.IsErrorCode(err, git.ErrUnbornBranch) {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+// finishRun records the objects just saved to storage: for the local filesystem backend this means
+// committing (and optionally pushing) the git repo rooted at conf.outDir; non-filesystem backends
+// (S3, GCS) have no git history of their own, so a manifest object is written instead.
+func finishRun(conf config, storage Storage, db *sqlx.DB, objects []dbObject) (err error) {
+	if !storage.IsFilesystem() {
+		return storage.Write(filepath.Join(conf.subDir(), "manifest.json"), buildManifest(conf.db.database, objects))
 	}
 
-	master, err := repo.LookupBranch("master", git.BranchLocal)
+	// Batch targets commonly share a repo (differing only in subDir/branch), so serialize the
+	// commit/push steps per outDir to avoid racing on the same .git/index and ref store.
+	mu := lockRepo(conf.outDir)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Get or init a git repo
+	repo, err := getRepo(conf.outDir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	commit, err = repo.LookupCommit(master.Target())
-
-	return commit, err
-}
+	// Commit all changed files
+	if err = commitChanges(repo, conf, db, objects); err != nil {
+		return err
+	}
 
-// buildCommitMessage builds a commit message detailing which database the commit concerns and which
-// files have been changed
-func buildCommitMessage(database string, changedPaths []string) string {
-	pathsString := strings.Join(changedPaths, "\n")
-	return fmt.Sprintf("Update with procedures from database '%v'\n\nThese files have changed:\n\n%v", database, pathsString)
+	// Publish to a remote, if one has been configured
+	return pushChanges(repo, conf.push, conf.branch)
 }
 
 func main() {
 	flag.Parse()
-	checkFatal(conf.isValid())
+	initLogging()
+	startMetricsServer(metricsAddr)
 
-	db, err := sqlx.Connect("mssql", conf.db.connectionString())
+	authors, err := loadAuthorMap(authorMapPath)
 	checkFatal(err)
+	loadedAuthors = authors
 
-	// Get all of the SP names from the database
-	names, err := getProcedureNames(db)
-	checkFatal(err)
+	if len(batchFilePath) > 0 {
+		batch, err := loadBatchConfig(batchFilePath)
+		checkFatal(err)
 
-	checkFatal(saveAllProcedures(names, 5, db, conf))
+		checkFatal(runBatch(batch))
+		return
+	}
 
-	// Get or init a git repo
-	repo, err := getRepo(conf.outDir)
-	checkFatal(err)
+	checkFatal(conf.isValid())
 
-	// Commit all changed files
-	checkFatal(commitChanges(repo, conf))
+	if watchInterval > 0 {
+		checkFatal(runWatch(conf, watchInterval))
+		return
+	}
+
+	checkFatal(processDatabase(conf))
 }
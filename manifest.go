@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// manifest describes the set of database objects mirrored for a database when the chosen Storage
+// backend has no git history of its own (S3, GCS) to record that information in.
+type manifest struct {
+	Database string     `json:"database"`
+	Objects  []dbObject `json:"objects"`
+}
+
+// buildManifest serializes the list of objects mirrored for database to JSON.
+func buildManifest(database string, objects []dbObject) []byte {
+	m := manifest{Database: database, Objects: objects}
+
+	// json.Marshal only fails on unsupported types (channels, funcs, cyclic refs), none of which
+	// appear here, so a marshal error would indicate a programming mistake rather than bad input.
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectType identifies a kind of database object spkeeper can mirror. Each one gets its own
+// subdirectory under the database's output directory.
+type objectType string
+
+const (
+	objectTypeProcedure objectType = "procedures"
+	objectTypeFunction  objectType = "functions"
+	objectTypeView      objectType = "views"
+	objectTypeTrigger   objectType = "triggers"
+)
+
+// allObjectTypes lists every object type spkeeper knows how to mirror, in the order they're fetched.
+func allObjectTypes() []objectType {
+	return []objectType{objectTypeProcedure, objectTypeFunction, objectTypeView, objectTypeTrigger}
+}
+
+// dbObject identifies a single database object to be mirrored, e.g. a stored procedure or view.
+type dbObject struct {
+	Type     objectType
+	Schema   string
+	Name     string
+	ObjectID int64
+}
+
+// qualifiedName returns the object's schema-qualified name as used in T-SQL, e.g. "dbo.my_proc".
+func (o dbObject) qualifiedName() string {
+	return fmt.Sprintf("%v.%v", o.Schema, o.Name)
+}
+
+// relPath returns the path obj is written to, relative to the database's output subdirectory:
+// <object type>/<schema>.<name>.sql.
+func (o dbObject) relPath() string {
+	return filepath.Join(string(o.Type), fmt.Sprintf("%v.sql", o.qualifiedName()))
+}
+
+// includeExclude holds the --include/--exclude flag values and resolves them to the final set of
+// object types to fetch.
+type includeExclude struct {
+	include string
+	exclude string
+}
+
+func init() {
+	flag.StringVar(&objectFilter.include, "include", "", "comma-separated object types to mirror (procedures,functions,views,triggers); defaults to all")
+	flag.StringVar(&objectFilter.exclude, "exclude", "", "comma-separated object types to skip")
+}
+
+// objectFilter holds the parsed --include/--exclude flags.
+var objectFilter includeExclude
+
+// objectTypes resolves the --include/--exclude flags to the final list of object types to fetch.
+func (f includeExclude) objectTypes() ([]objectType, error) {
+	all := allObjectTypes()
+
+	types := all
+	if len(f.include) > 0 {
+		types = nil
+		for _, name := range strings.Split(f.include, ",") {
+			t, err := parseObjectType(name)
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, t)
+		}
+	}
+
+	if len(f.exclude) > 0 {
+		excluded := make(map[objectType]bool)
+		for _, name := range strings.Split(f.exclude, ",") {
+			t, err := parseObjectType(name)
+			if err != nil {
+				return nil, err
+			}
+			excluded[t] = true
+		}
+
+		filtered := make([]objectType, 0, len(types))
+		for _, t := range types {
+			if !excluded[t] {
+				filtered = append(filtered, t)
+			}
+		}
+		types = filtered
+	}
+
+	return types, nil
+}
+
+// parseObjectType validates and normalises a single --include/--exclude entry.
+func parseObjectType(name string) (objectType, error) {
+	t := objectType(strings.ToLower(strings.TrimSpace(name)))
+	for _, known := range allObjectTypes() {
+		if t == known {
+			return t, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown object type %q", name)
+}
+
+// getObjects fetches the names of every object of the given types from the database.
+func getObjects(db *sqlx.DB, types []objectType) (objects []dbObject, err error) {
+	for _, t := range types {
+		fetched, err := getObjectNames(db, t)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, fetched...)
+	}
+
+	return objects, nil
+}
+
+// getObjectNames fetches the schema-qualified names of every object of the given type.
+func getObjectNames(db *sqlx.DB, t objectType) (objects []dbObject, err error) {
+	var query string
+
+	switch t {
+	case objectTypeProcedure:
+		query = "SELECT ROUTINE_SCHEMA, ROUTINE_NAME, OBJECT_ID(ROUTINE_SCHEMA + '.' + ROUTINE_NAME) FROM INFORMATION_SCHEMA.ROUTINES WHERE ROUTINE_TYPE='PROCEDURE'"
+	case objectTypeFunction:
+		query = "SELECT ROUTINE_SCHEMA, ROUTINE_NAME, OBJECT_ID(ROUTINE_SCHEMA + '.' + ROUTINE_NAME) FROM INFORMATION_SCHEMA.ROUTINES WHERE ROUTINE_TYPE='FUNCTION'"
+	case objectTypeView:
+		query = "SELECT TABLE_SCHEMA, TABLE_NAME, OBJECT_ID(TABLE_SCHEMA + '.' + TABLE_NAME) FROM INFORMATION_SCHEMA.VIEWS"
+	case objectTypeTrigger:
+		query = "SELECT OBJECT_SCHEMA_NAME(parent_id), name, object_id FROM sys.triggers WHERE parent_class = 1"
+	default:
+		return nil, fmt.Errorf("unknown object type %q", t)
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var schema, name string
+		var objectID int64
+		if err = rows.Scan(&schema, &name, &objectID); err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, dbObject{Type: t, Schema: schema, Name: name, ObjectID: objectID})
+	}
+
+	return objects, rows.Err()
+}
+
+// getModifyDates fetches the current modify_date for each of the given object IDs.
+func getModifyDates(db *sqlx.DB, objectIDs []int64) (map[int64]time.Time, error) {
+	dates := make(map[int64]time.Time, len(objectIDs))
+	if len(objectIDs) == 0 {
+		return dates, nil
+	}
+
+	query, args, err := sqlx.In("SELECT object_id, modify_date FROM sys.objects WHERE object_id IN (?)", objectIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var modifyDate time.Time
+		if err = rows.Scan(&id, &modifyDate); err != nil {
+			return nil, err
+		}
+		dates[id] = modifyDate
+	}
+
+	return dates, rows.Err()
+}
+
+// writeObjectBody reads the definition of obj from the database and writes it to the given Writer.
+// OBJECT_DEFINITION returns the whole definition in a single row/column, unlike sp_helptext which
+// splits long definitions across multiple rows and trips up database/sql's fixed-arity Scan.
+func writeObjectBody(db *sqlx.DB, obj dbObject, w io.Writer) (err error) {
+	var text sql.NullString
+	err = db.Get(&text, "SELECT OBJECT_DEFINITION(OBJECT_ID(?))", obj.qualifiedName())
+	if err != nil {
+		return fmt.Errorf("%v: %v", obj.qualifiedName(), err)
+	}
+
+	_, err = w.Write([]byte(text.String))
+	return err
+}
+
+// objectMeta carries the database-side provenance of a mirrored object, used to enrich commit
+// messages and attribute commits to the user that last changed the object.
+type objectMeta struct {
+	ModifyDate time.Time
+	// Login is the database user that executed the last ALTER, where SQL Server has recorded one
+	// (it only does so for modules created/altered WITH EXECUTE AS). Empty otherwise.
+	Login string
+}
+
+// getObjectMetadata reads obj's last-modified timestamp and, where available, the user that last
+// altered it.
+func getObjectMetadata(db *sqlx.DB, obj dbObject) (meta objectMeta, err error) {
+	var login sql.NullString
+
+	// execute_as_principal_id is database-scoped, so it must be resolved against
+	// sys.database_principals rather than the server-scoped sys.server_principals, or the join
+	// comes back NULL for essentially every object.
+	row := db.QueryRow(`
+		SELECT o.modify_date, dp.name
+		FROM sys.objects o
+		LEFT JOIN sys.sql_modules m ON m.object_id = o.object_id
+		LEFT JOIN sys.database_principals dp ON dp.principal_id = m.execute_as_principal_id
+		WHERE o.object_id = OBJECT_ID(?)`, obj.qualifiedName())
+
+	if err = row.Scan(&meta.ModifyDate, &login); err != nil {
+		return objectMeta{}, fmt.Errorf("%v: %v", obj.qualifiedName(), err)
+	}
+
+	meta.Login = login.String
+
+	return meta, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseObjectType(t *testing.T) {
+	cases := []struct {
+		name    string
+		want    objectType
+		wantErr bool
+	}{
+		{"procedures", objectTypeProcedure, false},
+		{"Functions", objectTypeFunction, false},
+		{" views ", objectTypeView, false},
+		{"bogus", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseObjectType(c.name)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("parseObjectType(%q) error = %v, wantErr %v", c.name, err, c.wantErr)
+			}
+			if err == nil && got != c.want {
+				t.Errorf("parseObjectType(%q) = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIncludeExcludeObjectTypes(t *testing.T) {
+	cases := []struct {
+		name    string
+		filter  includeExclude
+		want    []objectType
+		wantErr bool
+	}{
+		{"defaults to all", includeExclude{}, allObjectTypes(), false},
+		{"include narrows", includeExclude{include: "views,triggers"}, []objectType{objectTypeView, objectTypeTrigger}, false},
+		{"exclude removes", includeExclude{exclude: "functions"}, []objectType{objectTypeProcedure, objectTypeView, objectTypeTrigger}, false},
+		{"unknown include errors", includeExclude{include: "bogus"}, nil, true},
+		{"unknown exclude errors", includeExclude{exclude: "bogus"}, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.filter.objectTypes()
+			if (err != nil) != c.wantErr {
+				t.Fatalf("objectTypes() error = %v, wantErr %v", err, c.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, c.want) {
+				t.Errorf("objectTypes() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
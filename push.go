@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/libgit2/git2go"
+)
+
+// pushConfig holds everything needed to push the local mirror repo to a remote git host.
+type pushConfig struct {
+	// remoteURL is the git remote to push to, e.g. git@github.com:org/repo.git or
+	// https://github.com/org/repo.git. Pushing is skipped entirely when this is empty.
+	remoteURL string
+	// remoteBranch is the branch on the remote that the local commit branch (-branch) is pushed to.
+	remoteBranch string
+
+	// sshKeyPath and sshPublicKeyPath are the private/public key files used for SSH remotes.
+	// sshKeyPassphrase is used if the private key is encrypted.
+	sshKeyPath       string
+	sshPublicKeyPath string
+	sshKeyPassphrase string
+	knownHostsPath   string
+
+	// remoteUser and remoteToken authenticate HTTPS remotes.
+	remoteUser  string
+	remoteToken string
+
+	// createRemoteBranch allows pushing to a remote that has no refs yet, creating remoteBranch.
+	createRemoteBranch bool
+}
+
+func init() {
+	flag.StringVar(&conf.push.remoteURL, "remote", "", "git remote URL to push the mirror to (ssh or https), e.g. git@github.com:org/repo.git")
+	flag.StringVar(&conf.push.remoteBranch, "remote-branch", "master", "branch on the remote to push the local --branch to")
+	flag.StringVar(&conf.push.sshKeyPath, "ssh-key", "", "path to the SSH private key used to authenticate with --remote")
+	flag.StringVar(&conf.push.sshPublicKeyPath, "ssh-pubkey", "", "path to the SSH public key used to authenticate with --remote")
+	flag.StringVar(&conf.push.sshKeyPassphrase, "ssh-key-passphrase", "", "passphrase for --ssh-key, if it is encrypted")
+	flag.StringVar(&conf.push.knownHostsPath, "known-hosts", "", "path to a known_hosts file used to verify the remote's SSH host key")
+	flag.StringVar(&conf.push.remoteUser, "remote-user", "", "username for HTTPS authentication with --remote")
+	flag.StringVar(&conf.push.remoteToken, "remote-token", "", "password or access token for HTTPS authentication with --remote")
+	flag.BoolVar(&conf.push.createRemoteBranch, "create-remote-branch", true, "create --remote-branch on the remote if it does not already exist")
+}
+
+// pushChanges pushes refs/heads/<localBranch> to pushConf.remoteBranch on pushConf.remoteURL. It is
+// a no-op when no remote URL has been configured, so spkeeper keeps working purely locally by
+// default.
+func pushChanges(repo *git.Repository, pushConf pushConfig, localBranch string) (err error) {
+	if len(pushConf.remoteURL) == 0 {
+		return nil
+	}
+
+	remote, err := repo.Remotes.CreateAnonymous(pushConf.remoteURL)
+	if err != nil {
+		return err
+	}
+
+	refspec := fmt.Sprintf("refs/heads/%v:refs/heads/%v", localBranch, pushConf.remoteBranch)
+
+	callbacks := git.RemoteCallbacks{
+		CredentialsCallback:      pushConf.credentialsCallback,
+		CertificateCheckCallback: pushConf.certificateCheckCallback,
+	}
+
+	if !pushConf.createRemoteBranch {
+		exists, err := remoteBranchExists(remote, pushConf.remoteBranch, callbacks)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("remote branch %v does not exist and --create-remote-branch=false", pushConf.remoteBranch)
+		}
+	}
+
+	log.Info().Str("remote", pushConf.remoteURL).Str("refspec", refspec).Msg("pushing changes")
+
+	return remote.Push([]string{refspec}, &git.PushOptions{
+		RemoteCallbacks: callbacks,
+	})
+}
+
+// remoteBranchExists reports whether branch already exists on remote, by connecting for a push and
+// listing the remote's refs. Used to guard against silently creating a branch on a remote that
+// doesn't have it yet when --create-remote-branch is false.
+func remoteBranchExists(remote *git.Remote, branch string, callbacks git.RemoteCallbacks) (bool, error) {
+	if err := remote.ConnectPush(&callbacks, nil, nil); err != nil {
+		return false, err
+	}
+	defer remote.Disconnect()
+
+	heads, err := remote.Ls(fmt.Sprintf("refs/heads/%v", branch))
+	if err != nil {
+		return false, err
+	}
+
+	return len(heads) > 0, nil
+}
+
+// credentialsCallback authenticates against the remote using an SSH key pair when one has been
+// configured, falling back to HTTPS username/token auth otherwise.
+func (p pushConfig) credentialsCallback(url string, username string, allowedTypes git.CredType) (git.ErrorCode, *git.Cred) {
+	if len(p.sshKeyPath) > 0 {
+		user := username
+		if len(user) == 0 {
+			user = "git"
+		}
+
+		ret, cred := git.NewCredSshKey(user, p.sshPublicKeyPath, p.sshKeyPath, p.sshKeyPassphrase)
+		return git.ErrorCode(ret), &cred
+	}
+
+	ret, cred := git.NewCredUserpassPlaintext(p.remoteUser, p.remoteToken)
+	return git.ErrorCode(ret), &cred
+}
+
+// certificateCheckCallback verifies the remote's SSH host key against knownHostsPath when one is
+// configured. Without a known_hosts file, spkeeper accepts the certificate git2go has already
+// validated against the system's default known_hosts.
+func (p pushConfig) certificateCheckCallback(cert *git.Certificate, valid bool, hostname string) git.ErrorCode {
+	if len(p.knownHostsPath) == 0 {
+		if valid {
+			return 0
+		}
+		return git.ErrCertificate
+	}
+
+	knownHosts, err := ioutil.ReadFile(p.knownHostsPath)
+	if err != nil {
+		return git.ErrCertificate
+	}
+
+	if cert.Hostkey.Type&git.HostkeySHA256 != 0 {
+		fingerprint := cert.Hostkey.HashSHA256
+		if hostKeyKnown(knownHosts, hostname, fingerprint[:]) {
+			return 0
+		}
+	}
+
+	return git.ErrCertificate
+}
+
+// hostKeyKnown reports whether knownHosts contains a line for hostname whose key's SHA256
+// fingerprint matches fingerprint.
+func hostKeyKnown(knownHosts []byte, hostname string, fingerprint []byte) bool {
+	want := base64.StdEncoding.EncodeToString(fingerprint)
+
+	for _, line := range bytes.Split(knownHosts, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) < 3 {
+			continue
+		}
+
+		if !hasHost(fields[0], hostname) {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+
+		sum := sha256.Sum256(key)
+		if base64.StdEncoding.EncodeToString(sum[:]) == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasHost reports whether hostname appears as an exact entry in a known_hosts line's (possibly
+// comma-separated) host-pattern field, e.g. "github.com,192.30.255.113".
+func hasHost(field, hostname string) bool {
+	for _, host := range strings.Split(field, ",") {
+		if host == hostname {
+			return true
+		}
+	}
+
+	return false
+}
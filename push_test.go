@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestHostKeyKnown(t *testing.T) {
+	key := []byte("AAAAC3NzaC1lZDI1NTE5AAAAIBoGdpXmTkt")
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	sum := sha256.Sum256(key)
+	fingerprint := sum[:]
+
+	// sub.github.com is an unrelated entry that happens to contain "github.com" as a substring, and
+	// gitlab.com is listed alongside an IP in a single comma-separated field, as ssh-keyscan emits.
+	knownHosts := []byte(
+		"sub.github.com ssh-ed25519 " + encodedKey + "\n" +
+			"gitlab.com,192.30.255.113 ssh-ed25519 " + encodedKey + "\n",
+	)
+
+	cases := []struct {
+		name     string
+		hostname string
+		want     bool
+	}{
+		{"unrelated entry that is merely a substring match does not count", "github.com", false},
+		{"exact entry matches", "sub.github.com", true},
+		{"one of a comma-separated list matches", "gitlab.com", true},
+		{"substring of a listed host is not a match", "ithub.com", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := hostKeyKnown(knownHosts, c.hostname, fingerprint)
+			if got != c.want {
+				t.Errorf("hostKeyKnown(%q) = %v, want %v", c.hostname, got, c.want)
+			}
+		})
+	}
+}
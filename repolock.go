@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// repoLocksMu guards repoLocks.
+var repoLocksMu sync.Mutex
+
+// repoLocks holds one mutex per repo path, so concurrent batch targets that share an outDir (common
+// when targets differ only in subDir/branch) serialize their commit/push steps instead of racing on
+// the same .git/index and ref store.
+var repoLocks = map[string]*sync.Mutex{}
+
+// lockRepo returns the mutex guarding outDir, creating it on first use.
+func lockRepo(outDir string) *sync.Mutex {
+	key, err := filepath.Abs(outDir)
+	if err != nil {
+		key = outDir
+	}
+
+	repoLocksMu.Lock()
+	defer repoLocksMu.Unlock()
+
+	m, ok := repoLocks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		repoLocks[key] = m
+	}
+
+	return m
+}
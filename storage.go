@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is a destination for saved procedure bodies. The filesystem writer used by spkeeper since
+// its first version is one implementation; s3Storage and gcsStorage let -o point straight at a
+// bucket instead of a local git working directory.
+type Storage interface {
+	// Write stores data at relPath (a slash-separated path relative to the storage root, e.g.
+	// "mydb/procedures/dbo.my_proc.sql").
+	Write(relPath string, data []byte) error
+
+	// Delete removes relPath, used to clean up mirrored objects that have been dropped from the
+	// database. It is not an error for relPath to already be gone.
+	Delete(relPath string) error
+
+	// List returns the relPath of every object currently stored under prefix, used to find mirrored
+	// objects that no longer exist in the database so they can be removed.
+	List(prefix string) ([]string, error)
+
+	// IsFilesystem reports whether this backend is a local directory backing a git working tree.
+	// The commit step only applies when this is true; other backends write a manifest instead.
+	IsFilesystem() bool
+}
+
+// newStorage builds the Storage backend named by rawURL. "s3://bucket/prefix" and "gs://bucket/prefix"
+// select the S3 and GCS backends respectively; anything else is treated as a local filesystem path.
+func newStorage(rawURL string) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Scheme) == 0 {
+		return &localStorage{root: rawURL}, nil
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Storage(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		return newGCSStorage(u.Host, strings.TrimPrefix(u.Path, "/"))
+	default:
+		return &localStorage{root: rawURL}, nil
+	}
+}
+
+// localStorage writes procedure bodies to files under a local directory, as spkeeper has always done.
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) Write(relPath string, data []byte) error {
+	outFileName := filepath.Join(s.root, relPath)
+	if err := os.MkdirAll(filepath.Dir(outFileName), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(outFileName, data, 0600)
+}
+
+func (s *localStorage) Delete(relPath string) error {
+	err := os.Remove(filepath.Join(s.root, relPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localStorage) List(prefix string) (paths []string, err error) {
+	root := filepath.Join(s.root, prefix)
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		paths = append(paths, relPath)
+		return nil
+	})
+
+	return paths, err
+}
+
+func (s *localStorage) IsFilesystem() bool {
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/api/iterator"
+)
+
+// s3Storage writes procedure bodies as objects in an S3 bucket, keyed by prefix+relPath.
+type s3Storage struct {
+	bucket   string
+	prefix   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func newS3Storage(bucket, prefix string) (*s3Storage, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.New(sess)
+
+	return &s3Storage{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   client,
+		uploader: s3manager.NewUploader(sess, func(u *s3manager.Uploader) { u.S3 = client }),
+	}, nil
+}
+
+func (s *s3Storage) Write(relPath string, data []byte) error {
+	key := s.prefix + "/" + relPath
+
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("s3://%v/%v: %v", s.bucket, key, err)
+	}
+
+	return nil
+}
+
+func (s *s3Storage) Delete(relPath string) error {
+	key := s.prefix + "/" + relPath
+
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("s3://%v/%v: %v", s.bucket, key, err)
+	}
+
+	return nil
+}
+
+func (s *s3Storage) List(prefix string) (paths []string, err error) {
+	fullPrefix := s.prefix + "/" + prefix
+
+	listErr := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(fullPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			paths = append(paths, strings.TrimPrefix(strings.TrimPrefix(*obj.Key, s.prefix), "/"))
+		}
+		return true
+	})
+	if listErr != nil {
+		return nil, fmt.Errorf("s3://%v/%v: %v", s.bucket, fullPrefix, listErr)
+	}
+
+	return paths, nil
+}
+
+func (s *s3Storage) IsFilesystem() bool {
+	return false
+}
+
+// gcsStorage writes procedure bodies as objects in a GCS bucket, keyed by prefix+relPath.
+type gcsStorage struct {
+	bucket string
+	prefix string
+	client *storage.Client
+	ctx    context.Context
+}
+
+func newGCSStorage(bucket, prefix string) (*gcsStorage, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcsStorage{bucket: bucket, prefix: prefix, client: client, ctx: ctx}, nil
+}
+
+func (s *gcsStorage) Write(relPath string, data []byte) error {
+	key := s.prefix + "/" + relPath
+
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(s.ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("gs://%v/%v: %v", s.bucket, key, err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("gs://%v/%v: %v", s.bucket, key, err)
+	}
+
+	return nil
+}
+
+func (s *gcsStorage) Delete(relPath string) error {
+	key := s.prefix + "/" + relPath
+
+	err := s.client.Bucket(s.bucket).Object(key).Delete(s.ctx)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("gs://%v/%v: %v", s.bucket, key, err)
+	}
+
+	return nil
+}
+
+func (s *gcsStorage) List(prefix string) (paths []string, err error) {
+	fullPrefix := s.prefix + "/" + prefix
+
+	it := s.client.Bucket(s.bucket).Objects(s.ctx, &storage.Query{Prefix: fullPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("gs://%v/%v: %v", s.bucket, fullPrefix, err)
+		}
+
+		paths = append(paths, strings.TrimPrefix(strings.TrimPrefix(attrs.Name, s.prefix), "/"))
+	}
+
+	return paths, nil
+}
+
+func (s *gcsStorage) IsFilesystem() bool {
+	return false
+}
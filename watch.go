@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// watchInterval is how often spkeeper re-polls the database in --watch mode. Zero (the default)
+// means run once and exit, as spkeeper has always done.
+var watchInterval time.Duration
+
+func init() {
+	flag.DurationVar(&watchInterval, "watch", 0, "if set, keep running and re-poll the database on this interval instead of exiting after one run")
+}
+
+// watchState tracks the last-seen modify_date of every object spkeeper has mirrored, so --watch
+// cycles only need to re-fetch bodies for objects that have actually changed.
+type watchState struct {
+	// LastSeen maps a "database/object_id" key to the modify_date spkeeper last saw for that object.
+	LastSeen map[string]time.Time `json:"lastSeen"`
+}
+
+// stateKey builds the watchState.LastSeen key for an object in the given database.
+func stateKey(database string, objectID int64) string {
+	return fmt.Sprintf("%v/%v", database, objectID)
+}
+
+// loadWatchState reads the watch state from path, returning an empty state if the file doesn't
+// exist yet (e.g. on the very first run).
+func loadWatchState(path string) (*watchState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &watchState{LastSeen: map[string]time.Time{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := &watchState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// save writes the watch state to path, creating its parent directory if necessary.
+func (s *watchState) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// watchStatePath is the state file used to track modify_dates across --watch cycles, kept inside
+// the repo so it travels with it.
+func watchStatePath(conf config) string {
+	return filepath.Join(conf.outDir, ".spkeeper", "state.json")
+}
+
+// runWatch runs processDatabase-equivalent cycles on conf every interval until interrupted,
+// fetching object bodies only for objects that changed since the last cycle. A SIGINT lets the
+// in-flight cycle (including its commit) finish before spkeeper exits.
+func runWatch(conf config, interval time.Duration) error {
+	statePath := watchStatePath(conf)
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := runWatchCycle(conf, state); err != nil {
+			log.Error().Err(err).Msg("watch cycle failed")
+		}
+
+		if err := state.save(statePath); err != nil {
+			log.Error().Err(err).Msg("failed to save watch state")
+		}
+
+		select {
+		case <-sigCh:
+			log.Info().Msg("received interrupt, shutting down")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWatchCycle fetches the current set of objects in conf's database, mirrors only those whose
+// modify_date is newer than what state last recorded, and commits the result.
+func runWatchCycle(conf config, state *watchState) error {
+	db, err := sqlx.Connect("mssql", conf.db.connectionString())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	storage, err := newStorage(conf.outDir)
+	if err != nil {
+		return err
+	}
+
+	types, err := objectFilter.objectTypes()
+	if err != nil {
+		return err
+	}
+
+	objects, err := getObjects(db, types)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(objects))
+	for i, obj := range objects {
+		ids[i] = obj.ObjectID
+	}
+
+	modifyDates, err := getModifyDates(db, ids)
+	if err != nil {
+		return err
+	}
+
+	var changed []dbObject
+	for _, obj := range objects {
+		key := stateKey(conf.db.database, obj.ObjectID)
+		modifyDate := modifyDates[obj.ObjectID]
+
+		if lastSeen, ok := state.LastSeen[key]; !ok || modifyDate.After(lastSeen) {
+			changed = append(changed, obj)
+		}
+	}
+
+	if len(changed) == 0 {
+		log.Info().Msg("no changes detected")
+		return nil
+	}
+
+	log.Info().Int("changed", len(changed)).Int("total", len(objects)).Msg("objects changed since last run")
+
+	if err = saveAllObjects(changed, 5, db, conf, storage); err != nil {
+		return err
+	}
+
+	if err = removeStaleObjects(storage, conf.subDir(), types, objects); err != nil {
+		return err
+	}
+
+	// A manifest lists every mirrored object, not just this cycle's changes.
+	recorded := changed
+	if !storage.IsFilesystem() {
+		recorded = objects
+	}
+
+	if err = finishRun(conf, storage, db, recorded); err != nil {
+		return err
+	}
+
+	// Only record modify_dates once the changed objects have actually been mirrored, so a failed
+	// cycle is retried on the next one.
+	for _, obj := range changed {
+		state.LastSeen[stateKey(conf.db.database, obj.ObjectID)] = modifyDates[obj.ObjectID]
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".spkeeper", "state.json")
+
+	state, err := loadWatchState(path)
+	if err != nil {
+		t.Fatalf("loadWatchState on missing file: %v", err)
+	}
+	if len(state.LastSeen) != 0 {
+		t.Fatalf("expected empty state for a missing file, got %v", state.LastSeen)
+	}
+
+	key := stateKey("mydb", 42)
+	state.LastSeen[key] = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if err := state.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	reloaded, err := loadWatchState(path)
+	if err != nil {
+		t.Fatalf("loadWatchState after save: %v", err)
+	}
+
+	if !reloaded.LastSeen[key].Equal(state.LastSeen[key]) {
+		t.Errorf("reloaded state = %v, want %v", reloaded.LastSeen, state.LastSeen)
+	}
+}